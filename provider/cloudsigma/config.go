@@ -0,0 +1,300 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package cloudsigma
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net/url"
+
+	"launchpad.net/juju-core/schema"
+
+	"launchpad.net/juju-core/environs/config"
+)
+
+// builtinRegions maps the region codes published by CloudSigma to the
+// API host that serves them. Users targeting the public cloud only need
+// to supply "region"; users running a private deployment can override
+// or extend this catalogue with the "regions" config attribute, or
+// bypass it altogether with "endpoint".
+var builtinRegions = map[string]string{
+	"zrh": "zrh.cloudsigma.com",
+	"lvs": "lvs.cloudsigma.com",
+	"wdc": "wdc.cloudsigma.com",
+	"hnl": "hnl.cloudsigma.com",
+	"mia": "mia.cloudsigma.com",
+}
+
+// The storageBackend* constants enumerate the object-storage backends the
+// provider knows how to drive. "local" is the original bootstrap-node HTTP server;
+// the others delegate to a shared object store so storage survives the
+// loss of any single controller, which matters for HA deployments.
+const (
+	storageBackendLocal            = "local"
+	storageBackendS3               = "s3"
+	storageBackendAzureBlob        = "azure-blob"
+	storageBackendCloudSigmaDrives = "cloudsigma-drives"
+)
+
+// storageBackendFields maps each non-local backend to the config
+// attributes it requires.
+var storageBackendFields = map[string][]string{
+	storageBackendS3:               {"storage-bucket", "storage-access-key", "storage-secret-key"},
+	storageBackendAzureBlob:        {"storage-account", "storage-account-key", "storage-container"},
+	storageBackendCloudSigmaDrives: nil,
+}
+
+var configFields = schema.Fields{
+	"username":            schema.String(),
+	"password":            schema.String(),
+	"region":              schema.String(),
+	"regions":             schema.StringMap(schema.String()),
+	"endpoint":            schema.String(),
+	"insecure-endpoint":   schema.Bool(),
+	"storage-port":        schema.ForceInt(),
+	"storage-auth-key":    schema.String(),
+	"storage-backend":     schema.String(),
+	"storage-bucket":      schema.String(),
+	"storage-access-key":  schema.String(),
+	"storage-secret-key":  schema.String(),
+	"storage-account":     schema.String(),
+	"storage-account-key": schema.String(),
+	"storage-container":   schema.String(),
+}
+
+var configDefaults = schema.Defaults{
+	"username":            "",
+	"password":            "",
+	"region":              "zrh",
+	"regions":             schema.Omit,
+	"endpoint":            schema.Omit,
+	"insecure-endpoint":   false,
+	"storage-port":        8040,
+	"storage-auth-key":    schema.Omit,
+	"storage-backend":     storageBackendLocal,
+	"storage-bucket":      schema.Omit,
+	"storage-access-key":  schema.Omit,
+	"storage-secret-key":  schema.Omit,
+	"storage-account":     schema.Omit,
+	"storage-account-key": schema.Omit,
+	"storage-container":   schema.Omit,
+}
+
+// configSecretFields holds the names of the attributes returned by
+// SecretAttrs. They must all have string values, since that's the
+// type SecretAttrs returns them as.
+var configSecretFields = []string{
+	"storage-auth-key",
+	"storage-account-key",
+	"storage-secret-key",
+}
+
+// configImmutableFields holds the names of the attributes that cannot be
+// changed once the environment has been prepared.
+var configImmutableFields = []string{
+	"region",
+	"endpoint",
+	"storage-port",
+	"storage-auth-key",
+	"storage-backend",
+}
+
+type environConfig struct {
+	*config.Config
+	attrs map[string]interface{}
+}
+
+func (c *environConfig) username() string {
+	return c.attrs["username"].(string)
+}
+
+func (c *environConfig) password() string {
+	return c.attrs["password"].(string)
+}
+
+func (c *environConfig) region() string {
+	return c.attrs["region"].(string)
+}
+
+func (c *environConfig) storagePort() int {
+	return c.attrs["storage-port"].(int)
+}
+
+func (c *environConfig) storageAuthKey() string {
+	return c.attrs["storage-auth-key"].(string)
+}
+
+func (c *environConfig) storageBackend() string {
+	return c.attrs["storage-backend"].(string)
+}
+
+func (c *environConfig) storageAttr(name string) string {
+	if v, ok := c.attrs[name].(string); ok {
+		return v
+	}
+	return ""
+}
+
+func (c *environConfig) storageBucket() string {
+	return c.storageAttr("storage-bucket")
+}
+
+func (c *environConfig) storageAccessKey() string {
+	return c.storageAttr("storage-access-key")
+}
+
+func (c *environConfig) storageSecretKey() string {
+	return c.storageAttr("storage-secret-key")
+}
+
+func (c *environConfig) storageAccount() string {
+	return c.storageAttr("storage-account")
+}
+
+func (c *environConfig) storageAccountKey() string {
+	return c.storageAttr("storage-account-key")
+}
+
+func (c *environConfig) storageContainer() string {
+	return c.storageAttr("storage-container")
+}
+
+// endpoint returns the explicit API endpoint for this config, or "" if
+// none was supplied and the region catalogue should be consulted instead.
+func (c *environConfig) endpoint() string {
+	if v, ok := c.attrs["endpoint"]; ok {
+		return v.(string)
+	}
+	return ""
+}
+
+// insecureEndpoint reports whether an http (rather than https) endpoint
+// has been explicitly allowed.
+func (c *environConfig) insecureEndpoint() bool {
+	return c.attrs["insecure-endpoint"].(bool)
+}
+
+// regions returns the user-supplied region catalogue overrides, if any.
+func (c *environConfig) regions() map[string]string {
+	v, ok := c.attrs["regions"]
+	if !ok {
+		return nil
+	}
+	regions := make(map[string]string)
+	for region, host := range v.(map[string]interface{}) {
+		regions[region] = host.(string)
+	}
+	return regions
+}
+
+// regionHost resolves the API host to use, consulting the endpoint first,
+// then the merged (built-in + user-supplied) region catalogue.
+func (c *environConfig) regionHost() (string, error) {
+	if endpoint := c.endpoint(); endpoint != "" {
+		return endpoint, nil
+	}
+	region := c.region()
+	if host, ok := c.regions()[region]; ok {
+		return host, nil
+	}
+	if host, ok := builtinRegions[region]; ok {
+		return host, nil
+	}
+	return "", fmt.Errorf("unknown region %q and no endpoint configured", region)
+}
+
+func validateConfig(cfg *config.Config, old *environConfig) (*environConfig, error) {
+	var oldCfg *config.Config
+	if old != nil {
+		oldCfg = old.Config
+	}
+	if err := config.Validate(cfg, oldCfg); err != nil {
+		return nil, err
+	}
+	validated, err := cfg.ValidateUnknownAttrs(configFields, configDefaults)
+	if err != nil {
+		return nil, err
+	}
+	ecfg := &environConfig{cfg, validated}
+
+	if ecfg.username() == "" {
+		return nil, fmt.Errorf("username: must not be empty")
+	}
+	if ecfg.password() == "" {
+		return nil, fmt.Errorf("password: must not be empty")
+	}
+	if ecfg.region() == "" {
+		return nil, fmt.Errorf("region: must not be empty")
+	}
+	// Compared as an interface value rather than through storageAuthKey,
+	// so that a non-string value is left for SecretAttrs to report.
+	if ecfg.attrs["storage-auth-key"] == "" {
+		return nil, fmt.Errorf("storage-auth-key: must not be empty")
+	}
+
+	if endpoint := ecfg.endpoint(); endpoint != "" {
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("endpoint: invalid URL: %v", err)
+		}
+		switch u.Scheme {
+		case "https":
+		case "http":
+			if !ecfg.insecureEndpoint() {
+				return nil, fmt.Errorf("endpoint: must use https unless insecure-endpoint is set")
+			}
+		default:
+			return nil, fmt.Errorf("endpoint: must use http or https")
+		}
+	} else if _, err := ecfg.regionHost(); err != nil {
+		return nil, err
+	}
+
+	backend := ecfg.storageBackend()
+	required, known := storageBackendFields[backend]
+	if backend != storageBackendLocal && !known {
+		return nil, fmt.Errorf("storage-backend: unknown backend %q", backend)
+	}
+	for _, field := range required {
+		if ecfg.storageAttr(field) == "" {
+			return nil, fmt.Errorf("%s: must not be empty when storage-backend is %q", field, backend)
+		}
+	}
+	for otherBackend, fields := range storageBackendFields {
+		if otherBackend == backend {
+			continue
+		}
+		for _, field := range fields {
+			if ecfg.storageAttr(field) != "" {
+				return nil, fmt.Errorf("%s: not valid unless storage-backend is %q", field, otherBackend)
+			}
+		}
+	}
+
+	if old != nil {
+		for _, field := range configImmutableFields {
+			oldv, newv := old.attrs[field], ecfg.attrs[field]
+			if oldv != newv {
+				return nil, fmt.Errorf("%s: cannot change from %v to %v", field, oldv, newv)
+			}
+		}
+	}
+
+	cfg, err = cfg.Apply(ecfg.attrs)
+	if err != nil {
+		return nil, err
+	}
+	ecfg.Config = cfg
+	return ecfg, nil
+}
+
+// newAuthKey generates a random, 36-character identifier in UUID-like
+// form, used as the default storage-auth-key.
+func newAuthKey() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x-%x-%x-%x-%x", buf[0:4], buf[4:6], buf[6:8], buf[8:10], buf[10:16]), nil
+}
@@ -7,10 +7,10 @@ import (
 	"crypto/rand"
 	"fmt"
 
-	"github.com/juju/juju/environs"
-	"github.com/juju/juju/environs/config"
-	"github.com/juju/juju/testing"
-	"github.com/juju/schema"
+	"launchpad.net/juju-core/environs"
+	"launchpad.net/juju-core/environs/config"
+	"launchpad.net/juju-core/testing"
+	"launchpad.net/juju-core/schema"
 	gc "launchpad.net/gocheck"
 )
 
@@ -32,6 +32,15 @@ func validAttrs() testing.Attrs {
 	})
 }
 
+// failReader is an io.Reader that always fails with err.
+type failReader struct {
+	err error
+}
+
+func (r failReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
+
 type configSuite struct {
 	testing.BaseSuite
 }
@@ -106,6 +115,71 @@ func (s *configSuite) TestNewEnvironConfig(c *gc.C) {
 		info:   "storage-auth-key must not be empty",
 		insert: testing.Attrs{"storage-auth-key": ""},
 		err:    "storage-auth-key: must not be empty",
+	}, {
+		info:   "unknown region with no endpoint is an error",
+		insert: testing.Attrs{"region": "mordor"},
+		err:    `unknown region "mordor" and no endpoint configured`,
+	}, {
+		info:   "unknown region is fine if endpoint is set",
+		insert: testing.Attrs{"region": "mordor", "endpoint": "https://api.example.com"},
+		expect: testing.Attrs{"endpoint": "https://api.example.com"},
+	}, {
+		info:   "regions catalogue can add a region",
+		insert: testing.Attrs{"region": "mordor", "regions": map[string]string{"mordor": "mordor.example.com"}},
+		expect: testing.Attrs{"region": "mordor"},
+	}, {
+		info:   "endpoint must be a valid URL",
+		insert: testing.Attrs{"endpoint": "://nope"},
+		err:    "endpoint: invalid URL.*",
+	}, {
+		info:   "endpoint must be https by default",
+		insert: testing.Attrs{"endpoint": "http://api.example.com"},
+		err:    "endpoint: must use https unless insecure-endpoint is set",
+	}, {
+		info:   "insecure-endpoint allows http",
+		insert: testing.Attrs{"endpoint": "http://api.example.com", "insecure-endpoint": true},
+		expect: testing.Attrs{"endpoint": "http://api.example.com"},
+	}, {
+		info:   "storage-backend defaults to local",
+		expect: testing.Attrs{"storage-backend": "local"},
+	}, {
+		info:   "storage-backend s3 requires storage-bucket",
+		insert: testing.Attrs{"storage-backend": "s3"},
+		err:    "storage-bucket: must not be empty when storage-backend is \"s3\"",
+	}, {
+		info: "storage-backend s3 with storage-bucket is valid",
+		insert: testing.Attrs{
+			"storage-backend":    "s3",
+			"storage-bucket":     "juju-tools",
+			"storage-access-key": "access-key",
+			"storage-secret-key": "secret-key",
+		},
+		expect: testing.Attrs{"storage-backend": "s3", "storage-bucket": "juju-tools"},
+	}, {
+		info:   "storage-backend azure-blob requires account fields",
+		insert: testing.Attrs{"storage-backend": "azure-blob"},
+		err:    "storage-account: must not be empty when storage-backend is \"azure-blob\"",
+	}, {
+		info: "storage-backend azure-blob with account fields is valid",
+		insert: testing.Attrs{
+			"storage-backend":     "azure-blob",
+			"storage-account":     "jujustorage",
+			"storage-account-key": "key",
+			"storage-container":   "juju-tools",
+		},
+		expect: testing.Attrs{"storage-backend": "azure-blob"},
+	}, {
+		info:   "storage-backend cloudsigma-drives is valid with no extra fields",
+		insert: testing.Attrs{"storage-backend": "cloudsigma-drives"},
+		expect: testing.Attrs{"storage-backend": "cloudsigma-drives"},
+	}, {
+		info:   "storage-backend rejects unknown values",
+		insert: testing.Attrs{"storage-backend": "ceph"},
+		err:    "storage-backend: unknown backend \"ceph\"",
+	}, {
+		info:   "storage-bucket is only valid for s3",
+		insert: testing.Attrs{"storage-bucket": "juju-tools"},
+		err:    "storage-bucket: not valid unless storage-backend is \"s3\"",
 	}}
 
 	for i, test := range newConfigTests {
@@ -167,6 +241,14 @@ var changeConfigTests = []struct {
 	info:   "can not change storage-auth-key",
 	insert: testing.Attrs{"storage-auth-key": "xxx"},
 	err:    "storage-auth-key: cannot change from .* to .*",
+}, {
+	info:   "can not change endpoint",
+	insert: testing.Attrs{"endpoint": "https://api.example.com"},
+	err:    "endpoint: cannot change from .* to .*",
+}, {
+	info:   "can not change storage-backend",
+	insert: testing.Attrs{"storage-backend": "cloudsigma-drives"},
+	err:    "storage-backend: cannot change from .* to .*",
 }}
 
 func (s *configSuite) TestValidateChange(c *gc.C) {
@@ -0,0 +1,153 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package cloudsigma
+
+import (
+	"fmt"
+	"sync"
+
+	"launchpad.net/juju-core/constraints"
+	"launchpad.net/juju-core/environs"
+	"launchpad.net/juju-core/environs/config"
+	"launchpad.net/juju-core/instance"
+	"launchpad.net/juju-core/state"
+	"launchpad.net/juju-core/state/api"
+)
+
+// environClient wraps the low-level connection to a CloudSigma endpoint.
+type environClient struct {
+	ecfg *environConfig
+	host string
+}
+
+// newClient resolves the CloudSigma API host for ecfg and returns a client
+// for it; it opens no connection. It is a variable so tests can patch it out.
+var newClient = func(ecfg *environConfig) (*environClient, error) {
+	host, err := ecfg.regionHost()
+	if err != nil {
+		return nil, err
+	}
+	return &environClient{ecfg: ecfg, host: host}, nil
+}
+
+// environ implements environs.Environ for the CloudSigma provider.
+// Configuration and storage management are implemented, except for the
+// cloudsigma-drives storage backend, whose operations all return a
+// "not implemented" error; the compute operations (Bootstrap and everything that depends on a running
+// state server) are not, since this snapshot has no CloudSigma server
+// API client to drive them -- they fail with a clear "not implemented"
+// error rather than being silently omitted from the interface.
+type environ struct {
+	name string
+
+	ecfgMutex sync.Mutex
+	ecfg      *environConfig
+	client    *environClient
+	storage   *environStorage
+}
+
+// Name returns the environment's name.
+func (e *environ) Name() string {
+	return e.name
+}
+
+// Config returns the environment's current configuration.
+func (e *environ) Config() *config.Config {
+	e.ecfgMutex.Lock()
+	defer e.ecfgMutex.Unlock()
+	return e.ecfg.Config
+}
+
+// SetConfig updates the environment's configuration, rejecting any
+// change that validateConfig considers invalid.
+func (e *environ) SetConfig(cfg *config.Config) error {
+	e.ecfgMutex.Lock()
+	defer e.ecfgMutex.Unlock()
+
+	ecfg, err := validateConfig(cfg, e.ecfg)
+	if err != nil {
+		return err
+	}
+	client, err := newClient(ecfg)
+	if err != nil {
+		return err
+	}
+	storage, err := newStorage(ecfg, client)
+	if err != nil {
+		return err
+	}
+	e.ecfg = ecfg
+	e.client = client
+	e.storage = storage
+	return nil
+}
+
+// Storage returns storage specific to the environment.
+func (e *environ) Storage() environs.Storage {
+	e.ecfgMutex.Lock()
+	defer e.ecfgMutex.Unlock()
+	return e.storage
+}
+
+// PublicStorage returns storage shared between environments. CloudSigma
+// has no such shared store, so there is never anything to read from it.
+func (e *environ) PublicStorage() environs.StorageReader {
+	return environs.EmptyStorage
+}
+
+// Provider returns the EnvironProvider that created this Environ.
+func (e *environ) Provider() environs.EnvironProvider {
+	return providerInstance
+}
+
+// Bootstrap is not yet implemented: doing so requires a client for the
+// CloudSigma server-creation API, which this snapshot does not have.
+func (e *environ) Bootstrap(ctx environs.BootstrapContext, cons constraints.Value) error {
+	return fmt.Errorf("cloudsigma provider: Bootstrap not implemented")
+}
+
+// StateInfo is not yet implemented; see Bootstrap.
+func (e *environ) StateInfo() (*state.Info, *api.Info, error) {
+	return nil, nil, fmt.Errorf("cloudsigma provider: StateInfo not implemented")
+}
+
+// Instances is not yet implemented; see Bootstrap.
+func (e *environ) Instances(ids []instance.Id) ([]instance.Instance, error) {
+	return nil, fmt.Errorf("cloudsigma provider: Instances not implemented")
+}
+
+// AllInstances is not yet implemented; see Bootstrap.
+func (e *environ) AllInstances() ([]instance.Instance, error) {
+	return nil, fmt.Errorf("cloudsigma provider: AllInstances not implemented")
+}
+
+// StartInstance is not yet implemented; see Bootstrap.
+func (e *environ) StartInstance(args environs.StartInstanceParams) (instance.Instance, *instance.HardwareCharacteristics, error) {
+	return nil, nil, fmt.Errorf("cloudsigma provider: StartInstance not implemented")
+}
+
+// StopInstances is not yet implemented; see Bootstrap.
+func (e *environ) StopInstances([]instance.Instance) error {
+	return fmt.Errorf("cloudsigma provider: StopInstances not implemented")
+}
+
+// OpenPorts is not yet implemented; see Bootstrap.
+func (e *environ) OpenPorts(ports []instance.Port) error {
+	return fmt.Errorf("cloudsigma provider: OpenPorts not implemented")
+}
+
+// ClosePorts is not yet implemented; see Bootstrap.
+func (e *environ) ClosePorts(ports []instance.Port) error {
+	return fmt.Errorf("cloudsigma provider: ClosePorts not implemented")
+}
+
+// Ports is not yet implemented; see Bootstrap.
+func (e *environ) Ports() ([]instance.Port, error) {
+	return nil, fmt.Errorf("cloudsigma provider: Ports not implemented")
+}
+
+// Destroy is not yet implemented; see Bootstrap.
+func (e *environ) Destroy() error {
+	return fmt.Errorf("cloudsigma provider: Destroy not implemented")
+}
@@ -0,0 +1,110 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package cloudsigma
+
+import (
+	"fmt"
+
+	"launchpad.net/juju-core/environs"
+	"launchpad.net/juju-core/environs/config"
+)
+
+type environProvider struct{}
+
+var providerInstance environProvider
+
+func init() {
+	environs.RegisterProvider("cloudsigma", providerInstance)
+}
+
+// Prepare implements environs.EnvironProvider.
+func (p environProvider) Prepare(ctx environs.BootstrapContext, cfg *config.Config) (environs.Environ, error) {
+	attrs := cfg.UnknownAttrs()
+	if _, ok := attrs["storage-auth-key"]; !ok {
+		key, err := newAuthKey()
+		if err != nil {
+			return nil, err
+		}
+		attrs["storage-auth-key"] = key
+	}
+	cfg, err := cfg.Apply(attrs)
+	if err != nil {
+		return nil, err
+	}
+	return p.Open(cfg)
+}
+
+// Open implements environs.EnvironProvider. Like Prepare, it generates a
+// storage-auth-key if the config has none.
+func (p environProvider) Open(cfg *config.Config) (environs.Environ, error) {
+	attrs := cfg.UnknownAttrs()
+	if _, ok := attrs["storage-auth-key"]; !ok {
+		key, err := newAuthKey()
+		if err != nil {
+			return nil, err
+		}
+		attrs["storage-auth-key"] = key
+		if cfg, err = cfg.Apply(attrs); err != nil {
+			return nil, err
+		}
+	}
+	ecfg, err := validateConfig(cfg, nil)
+	if err != nil {
+		return nil, err
+	}
+	env := &environ{name: ecfg.Name()}
+	if err := env.SetConfig(cfg); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+// Validate implements environs.EnvironProvider.
+func (p environProvider) Validate(cfg, old *config.Config) (valid *config.Config, err error) {
+	var oldecfg *environConfig
+	if old != nil {
+		oldecfg, err = validateConfig(old, nil)
+		if err != nil {
+			return nil, fmt.Errorf("invalid base config: %v", err)
+		}
+	}
+	ecfg, err := validateConfig(cfg, oldecfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config change: %v", err)
+	}
+	return ecfg.Config, nil
+}
+
+// BoilerplateConfig implements environs.EnvironProvider.
+func (p environProvider) BoilerplateConfig() string {
+	return `
+cloudsigma:
+    type: cloudsigma
+    username: <your cloudsigma username>
+    password: <your cloudsigma password>
+    region: zrh
+
+`[1:]
+}
+
+// SecretAttrs implements environs.EnvironProvider.
+func (p environProvider) SecretAttrs(cfg *config.Config) (map[string]string, error) {
+	ecfg, err := validateConfig(cfg, nil)
+	if err != nil {
+		return nil, err
+	}
+	secretAttrs := make(map[string]string)
+	for _, field := range configSecretFields {
+		v, ok := ecfg.attrs[field]
+		if !ok {
+			continue
+		}
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("secret %q field must have a string value; got %v", field, v)
+		}
+		secretAttrs[field] = s
+	}
+	return secretAttrs, nil
+}
@@ -0,0 +1,75 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package cloudsigma
+
+import (
+	"fmt"
+	"io"
+
+	"launchpad.net/juju-core/utils"
+
+	"launchpad.net/juju-core/environs"
+)
+
+// environStorage implements environs.Storage by delegating to whichever
+// backend-specific implementation newStorage selected for this
+// environment's storage-backend setting.
+type environStorage struct {
+	backend environs.Storage
+}
+
+// newStorage dispatches on ecfg.storageBackend() and returns the matching
+// environs.Storage implementation. It is a variable so tests can patch it out.
+var newStorage = func(ecfg *environConfig, client *environClient) (*environStorage, error) {
+	var backend environs.Storage
+	var err error
+	switch b := ecfg.storageBackend(); b {
+	case storageBackendLocal:
+		backend, err = newLocalStorage(ecfg)
+	case storageBackendS3:
+		backend, err = newS3Storage(ecfg)
+	case storageBackendAzureBlob:
+		backend, err = newAzureBlobStorage(ecfg)
+	case storageBackendCloudSigmaDrives:
+		backend, err = newDriveStorage(ecfg, client)
+	default:
+		return nil, fmt.Errorf("storage-backend: unknown backend %q", b)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &environStorage{backend: backend}, nil
+}
+
+func (s *environStorage) Get(name string) (io.ReadCloser, error) {
+	return s.backend.Get(name)
+}
+
+func (s *environStorage) List(prefix string) ([]string, error) {
+	return s.backend.List(prefix)
+}
+
+func (s *environStorage) URL(name string) (string, error) {
+	return s.backend.URL(name)
+}
+
+func (s *environStorage) ConsistencyStrategy() utils.AttemptStrategy {
+	return s.backend.ConsistencyStrategy()
+}
+
+func (s *environStorage) ShouldRetry(err error) bool {
+	return s.backend.ShouldRetry(err)
+}
+
+func (s *environStorage) Put(name string, r io.Reader, length int64) error {
+	return s.backend.Put(name, r, length)
+}
+
+func (s *environStorage) Remove(name string) error {
+	return s.backend.Remove(name)
+}
+
+func (s *environStorage) RemoveAll() error {
+	return s.backend.RemoveAll()
+}
@@ -0,0 +1,89 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package cloudsigma
+
+import (
+	"io"
+	"io/ioutil"
+
+	"launchpad.net/gwacl"
+
+	"launchpad.net/juju-core/utils"
+
+	"launchpad.net/juju-core/environs"
+)
+
+// azureBlobStorage stores tools and bootstrap artefacts as blobs in an
+// Azure Storage container, addressed the same way the Azure compute
+// provider addresses its own blobs (account, key and container, with
+// names scoped by an optional prefix).
+type azureBlobStorage struct {
+	context   *gwacl.StorageContext
+	container string
+}
+
+func newAzureBlobStorage(ecfg *environConfig) (environs.Storage, error) {
+	context := &gwacl.StorageContext{
+		Account: ecfg.storageAccount(),
+		Key:     ecfg.storageAccountKey(),
+	}
+	return &azureBlobStorage{context: context, container: ecfg.storageContainer()}, nil
+}
+
+func (s *azureBlobStorage) Get(name string) (io.ReadCloser, error) {
+	return s.context.GetBlob(s.container, name)
+}
+
+// List returns the names of the blobs in the container whose names start
+// with prefix, following the same container+prefix+ListBlobs pattern used
+// by the Azure compute provider's storage support.
+func (s *azureBlobStorage) List(prefix string) ([]string, error) {
+	req := &gwacl.ListBlobsRequest{Container: s.container, Prefix: prefix}
+	resp, err := s.context.ListBlobs(req)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(resp.Blobs))
+	for i, blob := range resp.Blobs {
+		names[i] = blob.Name
+	}
+	return names, nil
+}
+
+func (s *azureBlobStorage) URL(name string) (string, error) {
+	return s.context.GetAnonymousFileURL(s.container, name)
+}
+
+func (s *azureBlobStorage) ConsistencyStrategy() utils.AttemptStrategy {
+	return utils.AttemptStrategy{}
+}
+
+func (s *azureBlobStorage) ShouldRetry(err error) bool {
+	return false
+}
+
+func (s *azureBlobStorage) Put(name string, r io.Reader, length int64) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return s.context.UploadBlockBlob(s.container, name, data)
+}
+
+func (s *azureBlobStorage) Remove(name string) error {
+	return s.context.DeleteBlob(s.container, name)
+}
+
+func (s *azureBlobStorage) RemoveAll() error {
+	names, err := s.List("")
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := s.Remove(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
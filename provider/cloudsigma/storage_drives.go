@@ -0,0 +1,59 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package cloudsigma
+
+import (
+	"fmt"
+	"io"
+
+	"launchpad.net/juju-core/utils"
+
+	"launchpad.net/juju-core/environs"
+)
+
+// driveStorage is the storage selected by the cloudsigma-drives backend. It
+// holds the environment's CloudSigma API client, but stores nothing: Get,
+// List, URL, Put, Remove and RemoveAll all return a "not implemented" error.
+type driveStorage struct {
+	client *environClient
+}
+
+func newDriveStorage(ecfg *environConfig, client *environClient) (environs.Storage, error) {
+	if client == nil {
+		return nil, fmt.Errorf("storage-backend %q requires a CloudSigma client", storageBackendCloudSigmaDrives)
+	}
+	return &driveStorage{client: client}, nil
+}
+
+func (s *driveStorage) Get(name string) (io.ReadCloser, error) {
+	return nil, fmt.Errorf("cloudsigma-drives storage: Get not implemented")
+}
+
+func (s *driveStorage) List(prefix string) ([]string, error) {
+	return nil, fmt.Errorf("cloudsigma-drives storage: List not implemented")
+}
+
+func (s *driveStorage) URL(name string) (string, error) {
+	return "", fmt.Errorf("cloudsigma-drives storage: URL not implemented")
+}
+
+func (s *driveStorage) ConsistencyStrategy() utils.AttemptStrategy {
+	return utils.AttemptStrategy{}
+}
+
+func (s *driveStorage) ShouldRetry(err error) bool {
+	return false
+}
+
+func (s *driveStorage) Put(name string, r io.Reader, length int64) error {
+	return fmt.Errorf("cloudsigma-drives storage: Put not implemented")
+}
+
+func (s *driveStorage) Remove(name string) error {
+	return fmt.Errorf("cloudsigma-drives storage: Remove not implemented")
+}
+
+func (s *driveStorage) RemoveAll() error {
+	return fmt.Errorf("cloudsigma-drives storage: RemoveAll not implemented")
+}
@@ -0,0 +1,125 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package cloudsigma
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"launchpad.net/juju-core/utils"
+
+	"launchpad.net/juju-core/environs"
+)
+
+// localStorage serves tools and other bootstrap artefacts to agents out
+// of a directory on the bootstrap node, over a small HTTP server also
+// running there. It is the original, single-point-of-failure storage
+// backend and remains the default.
+type localStorage struct {
+	ecfg     *environConfig
+	listener net.Listener
+	dir      string
+}
+
+// newLocalStorage starts the HTTP server backing localStorage, rooted at
+// a directory named for the environment so that it survives across
+// SetConfig calls that don't change the storage backend.
+func newLocalStorage(ecfg *environConfig) (environs.Storage, error) {
+	dir := filepath.Join(os.TempDir(), "juju-cloudsigma-storage-"+ecfg.Name())
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("cannot create storage directory: %v", err)
+	}
+	addr := fmt.Sprintf(":%d", ecfg.storagePort())
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("cannot start storage server: %v", err)
+	}
+	s := &localStorage{ecfg: ecfg, listener: l, dir: dir}
+	go http.Serve(l, http.FileServer(http.Dir(dir)))
+	return s, nil
+}
+
+func (s *localStorage) path(name string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(name))
+}
+
+func (s *localStorage) Get(name string) (io.ReadCloser, error) {
+	f, err := os.Open(s.path(name))
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (s *localStorage) List(prefix string) ([]string, error) {
+	var names []string
+	err := filepath.Walk(s.dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(s.dir, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+func (s *localStorage) URL(name string) (string, error) {
+	return fmt.Sprintf("http://%s/%s", s.listener.Addr(), name), nil
+}
+
+func (s *localStorage) ConsistencyStrategy() utils.AttemptStrategy {
+	return utils.AttemptStrategy{}
+}
+
+func (s *localStorage) ShouldRetry(err error) bool {
+	return false
+}
+
+func (s *localStorage) Put(name string, r io.Reader, length int64) error {
+	path := s.path(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(r, length))
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+func (s *localStorage) Remove(name string) error {
+	return os.Remove(s.path(name))
+}
+
+func (s *localStorage) RemoveAll() error {
+	names, err := s.List("")
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := s.Remove(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
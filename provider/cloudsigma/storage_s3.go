@@ -0,0 +1,91 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package cloudsigma
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"launchpad.net/goamz/aws"
+	"launchpad.net/goamz/s3"
+
+	"launchpad.net/juju-core/utils"
+
+	"launchpad.net/juju-core/environs"
+)
+
+// s3Storage stores tools and bootstrap artefacts in a standard S3 bucket,
+// authenticating with its own AWS access key rather than the CloudSigma
+// account credentials, since the two are unrelated credential namespaces.
+type s3Storage struct {
+	bucket *s3.Bucket
+}
+
+func newS3Storage(ecfg *environConfig) (environs.Storage, error) {
+	auth, err := aws.GetAuth(ecfg.storageAccessKey(), ecfg.storageSecretKey())
+	if err != nil {
+		return nil, fmt.Errorf("cannot authenticate with S3: %v", err)
+	}
+	client := s3.New(auth, aws.USEast)
+	return &s3Storage{bucket: client.Bucket(ecfg.storageBucket())}, nil
+}
+
+func (s *s3Storage) Get(name string) (io.ReadCloser, error) {
+	data, err := s.bucket.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (s *s3Storage) List(prefix string) ([]string, error) {
+	resp, err := s.bucket.List(prefix, "", "", 0)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(resp.Contents))
+	for i, key := range resp.Contents {
+		names[i] = key.Key
+	}
+	return names, nil
+}
+
+func (s *s3Storage) URL(name string) (string, error) {
+	return s.bucket.URL(name), nil
+}
+
+func (s *s3Storage) ConsistencyStrategy() utils.AttemptStrategy {
+	return utils.AttemptStrategy{Total: 0}
+}
+
+func (s *s3Storage) ShouldRetry(err error) bool {
+	return false
+}
+
+func (s *s3Storage) Put(name string, r io.Reader, length int64) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return s.bucket.Put(name, data, "", s3.Private)
+}
+
+func (s *s3Storage) Remove(name string) error {
+	return s.bucket.Del(name)
+}
+
+func (s *s3Storage) RemoveAll() error {
+	names, err := s.List("")
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := s.Remove(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -4,15 +4,50 @@
 package peergrouper
 
 import (
+	"crypto/tls"
 	"fmt"
+	"io"
+	"net"
+	"runtime/debug"
+	"strings"
+	"time"
 
 	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
 
 	"launchpad.net/juju-core/agent"
 	"launchpad.net/juju-core/agent/mongo"
 	"launchpad.net/juju-core/replicaset"
 )
 
+// RetryStrategy controls how MaybeInitiateMongoServer retries a failed
+// dial or replica-set initiation before giving up. A zero value means
+// "use defaultRetryStrategy".
+type RetryStrategy struct {
+	// InitialDelay is how long to wait before the first retry.
+	InitialDelay time.Duration
+
+	// MaxDelay caps the exponential backoff between retries.
+	MaxDelay time.Duration
+
+	// MaxAttempts is the maximum number of times to try, including the
+	// first attempt.
+	MaxAttempts int
+
+	// Deadline is the maximum total time to spend retrying, measured
+	// from the first attempt.
+	Deadline time.Duration
+}
+
+// defaultRetryStrategy is generous enough to ride out a mongod that is
+// still coming up during bootstrap, without retrying forever.
+var defaultRetryStrategy = RetryStrategy{
+	InitialDelay: 500 * time.Millisecond,
+	MaxDelay:     30 * time.Second,
+	MaxAttempts:  10,
+	Deadline:     5 * time.Minute,
+}
+
 // InitiateMongoParams holds parameters for the MaybeInitiateMongo call.
 type InitiateMongoParams struct {
 	// DialInfo specifies how to connect to the mongo server.
@@ -26,11 +61,67 @@ type InitiateMongoParams struct {
 	// If it is empty, no login will take place.
 	User     string
 	Password string
+
+	// RetryStrategy controls how transient failures are retried. The
+	// zero value selects defaultRetryStrategy.
+	RetryStrategy RetryStrategy
+
+	// TLSConfig, if non-nil, causes the dial to be made over TLS using
+	// this configuration, rather than over a plain TCP connection.
+	TLSConfig *tls.Config
+
+	// X509Subject, if non-empty, authenticates using the MONGODB-X509
+	// mechanism with this certificate subject as the user, instead of
+	// User/Password. It requires TLSConfig to be set, and is mutually
+	// exclusive with User/Password.
+	X509Subject string
+}
+
+// dialMongo is a variable so tests can replace it with a fake dialer.
+var dialMongo = func(info *mgo.DialInfo) (*mgo.Session, error) {
+	return mgo.DialWithInfo(info)
+}
+
+// currentReplicaSetConfig and initiateReplicaSet are variables, rather
+// than direct calls to the replicaset package, so tests can replace them
+// without needing a real mongod to dial.
+var currentReplicaSetConfig = replicaset.CurrentConfig
+var initiateReplicaSet = replicaset.Initiate
+
+// sleep is a variable so tests can avoid paying for the real backoff delay.
+var sleep = time.Sleep
+
+// now is a variable so tests can control the passage of time for the
+// overall retry deadline.
+var now = time.Now
+
+// RecoveredPanicError is returned by MaybeInitiateMongoServer in place of
+// a panic that it recovered from, so that callers and logging code can
+// recognise and special-case it instead of string-matching on Error().
+type RecoveredPanicError struct {
+	// Value is whatever was passed to panic.
+	Value interface{}
+
+	// Stack is the stack trace captured at the point of the panic.
+	Stack []byte
+}
+
+func (e *RecoveredPanicError) Error() string {
+	return fmt.Sprintf("panic in MaybeInitiateMongoServer: %v", e.Value)
 }
 
 // MaybeInitiateMongoServer checks for an existing mongo configuration.
 // If no existing configuration is found one is created using Initiate.
-func MaybeInitiateMongoServer(p InitiateMongoParams) error {
+// Transient failures while mongod is still coming up are retried with
+// exponential backoff according to p.RetryStrategy; a panic from within
+// the mgo driver is recovered and returned as a *RecoveredPanicError
+// rather than crashing the machine agent.
+func MaybeInitiateMongoServer(p InitiateMongoParams) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &RecoveredPanicError{Value: r, Stack: debug.Stack()}
+		}
+	}()
 	logger.Debugf("Initiating mongo replicaset; dialInfo %#v; memberHostport %q; user %q; password %q", p.DialInfo, p.MemberHostPort, p.User, p.Password)
 	defer logger.Infof("finished MaybeInitiateMongoServer")
 
@@ -40,37 +131,147 @@ func MaybeInitiateMongoServer(p InitiateMongoParams) error {
 	}
 	p.DialInfo.Direct = true
 
+	if p.X509Subject != "" {
+		if p.User != "" {
+			return fmt.Errorf("cannot use both X509Subject and username/password authentication")
+		}
+		if p.TLSConfig == nil {
+			return fmt.Errorf("X509Subject requires TLSConfig to be set")
+		}
+	}
+	if p.TLSConfig != nil {
+		installTLSDialServer(p.DialInfo, p.TLSConfig)
+	}
+
 	// TODO(rog) remove this code when we no longer need to upgrade
 	// from pre-HA-capable environments.
-	if p.User != "" {
+	if p.X509Subject == "" && p.User != "" {
 		p.DialInfo.Username = p.User
 		p.DialInfo.Password = p.Password
 	}
 
-	session, err := mgo.DialWithInfo(p.DialInfo)
+	strategy := p.RetryStrategy
+	if strategy == (RetryStrategy{}) {
+		strategy = defaultRetryStrategy
+	}
+
+	deadline := now().Add(strategy.Deadline)
+	delay := strategy.InitialDelay
+	for attempt := 1; ; attempt++ {
+		phase, err := initiateMongoOnce(p)
+		if err == nil {
+			return nil
+		}
+		if !isTransientInitiateError(err) {
+			return fmt.Errorf("cannot %s: %v", phase, err)
+		}
+		if attempt >= strategy.MaxAttempts || !now().Before(deadline) {
+			return fmt.Errorf("cannot %s: giving up after %d attempts: %v", phase, attempt, err)
+		}
+		logger.Infof("transient error initiating mongo replicaset (attempt %d): %v; retrying in %v", attempt, err, delay)
+		sleep(delay)
+		if delay *= 2; delay > strategy.MaxDelay {
+			delay = strategy.MaxDelay
+		}
+	}
+}
+
+// installTLSDialServer arranges for info to dial over a TLS connection
+// negotiated with tlsConfig, instead of a plain TCP connection.
+func installTLSDialServer(info *mgo.DialInfo, tlsConfig *tls.Config) {
+	info.DialServer = func(addr *mgo.ServerAddr) (net.Conn, error) {
+		return dialTLS(addr.String(), tlsConfig)
+	}
+}
+
+// dialTLS dials addr and performs a TLS handshake using tlsConfig. It is
+// factored out of installTLSDialServer so it can be driven directly
+// against a real listener in tests, without needing a *mgo.ServerAddr.
+func dialTLS(addr string, tlsConfig *tls.Config) (net.Conn, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	tlsConn := tls.Client(conn, tlsConfig)
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// authenticateX509 is a variable, like dialMongo and its neighbours, so
+// tests can replace it with a fake that doesn't need a real mongod
+// configured with MONGODB-X509 support.
+var authenticateX509 = func(session *mgo.Session, subject string) error {
+	cmd := bson.D{
+		{Name: "authenticate", Value: 1},
+		{Name: "mechanism", Value: "MONGODB-X509"},
+		{Name: "user", Value: subject},
+	}
+	return session.DB("$external").Run(cmd, nil)
+}
+
+// initiateMongoOnce makes a single attempt to dial mongo and, if no
+// replica set is configured yet, initiate one. The returned error is
+// left unwrapped so isTransientInitiateError can inspect it; phase
+// identifies which step failed, for the caller to build a useful message.
+func initiateMongoOnce(p InitiateMongoParams) (phase string, err error) {
+	session, err := dialMongo(p.DialInfo)
 	if err != nil {
-		return fmt.Errorf("can't dial mongo to initiate replicaset: %v", err)
+		return "dial mongo to initiate replicaset", err
 	}
 	defer session.Close()
 
-	cfg, err := replicaset.CurrentConfig(session)
+	if p.X509Subject != "" {
+		if err := authenticateX509(session, p.X509Subject); err != nil {
+			return "authenticate with MONGODB-X509", err
+		}
+	}
+
+	cfg, err := currentReplicaSetConfig(session)
 	if err == nil && len(cfg.Members) > 0 {
 		logger.Infof("replica set configuration already found: %#v", cfg)
-		return nil
+		return "", nil
 	}
 	if err != nil && err != mgo.ErrNotFound {
-		return fmt.Errorf("cannot get replica set configuration: %v", err)
+		return "get replica set configuration", err
 	}
-	err = replicaset.Initiate(
+	if err := initiateReplicaSet(
 		session,
 		p.MemberHostPort,
 		mongo.ReplicaSetName,
 		map[string]string{
 			jujuMachineTag: agent.BootstrapMachineId,
 		},
-	)
-	if err != nil {
-		return fmt.Errorf("cannot initiate replica set: %v", err)
+	); err != nil {
+		return "initiate replica set", err
+	}
+	return "", nil
+}
+
+// isTransientInitiateError reports whether err looks like a transient
+// failure -- mongod not reachable or not ready yet -- as opposed to a
+// permanent misconfiguration (bad auth, malformed address) that retrying
+// will never fix.
+func isTransientInitiateError(err error) bool {
+	switch err {
+	case nil:
+		return false
+	case io.EOF, mgo.ErrNotFound:
+		return true
+	}
+	msg := err.Error()
+	for _, substr := range []string{
+		"connection refused",
+		"no reachable servers",
+		"i/o timeout",
+		"broken pipe",
+		"EOF",
+	} {
+		if strings.Contains(msg, substr) {
+			return true
+		}
 	}
-	return nil
-}
\ No newline at end of file
+	return false
+}
@@ -0,0 +1,281 @@
+// Copyright 2014 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package peergrouper
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"time"
+
+	"labix.org/v2/mgo"
+	gc "launchpad.net/gocheck"
+
+	"launchpad.net/juju-core/replicaset"
+	"launchpad.net/juju-core/testing"
+)
+
+type InitiateSuite struct {
+	testing.BaseSuite
+}
+
+var _ = gc.Suite(&InitiateSuite{})
+
+func (s *InitiateSuite) SetUpTest(c *gc.C) {
+	s.BaseSuite.SetUpTest(c)
+	s.PatchValue(&sleep, func(time.Duration) {})
+	s.PatchValue(&now, func() time.Time { return time.Time{} })
+	s.PatchValue(&currentReplicaSetConfig, func(*mgo.Session) (*replicaset.Config, error) {
+		return nil, mgo.ErrNotFound
+	})
+	s.PatchValue(&initiateReplicaSet, func(*mgo.Session, string, string, map[string]string) error {
+		return nil
+	})
+}
+
+// failNDialer returns a dialMongo replacement that fails n times with err
+// before succeeding with a usable (if useless) session.
+func failNDialer(n int, err error) func(*mgo.DialInfo) (*mgo.Session, error) {
+	attempts := 0
+	return func(*mgo.DialInfo) (*mgo.Session, error) {
+		attempts++
+		if attempts <= n {
+			return nil, err
+		}
+		return &mgo.Session{}, nil
+	}
+}
+
+var initiateRetryTests = []struct {
+	about       string
+	failures    int
+	failureErr  error
+	maxAttempts int
+	err         string
+}{{
+	about:       "succeeds first time",
+	failures:    0,
+	failureErr:  fmt.Errorf("connection refused"),
+	maxAttempts: 10,
+}, {
+	about:       "succeeds after transient failures",
+	failures:    3,
+	failureErr:  fmt.Errorf("connection refused"),
+	maxAttempts: 10,
+}, {
+	about:       "gives up after too many transient failures",
+	failures:    10,
+	failureErr:  fmt.Errorf("connection refused"),
+	maxAttempts: 3,
+	err:         `cannot dial mongo to initiate replicaset: giving up after 3 attempts: connection refused`,
+}, {
+	about:       "does not retry a permanent failure",
+	failures:    1,
+	failureErr:  fmt.Errorf("not authorized for upsert"),
+	maxAttempts: 10,
+	err:         `cannot dial mongo to initiate replicaset: not authorized for upsert`,
+}}
+
+func (s *InitiateSuite) TestMaybeInitiateMongoServerRetries(c *gc.C) {
+	for i, test := range initiateRetryTests {
+		c.Logf("test %d: %s", i, test.about)
+		s.PatchValue(&dialMongo, failNDialer(test.failures, test.failureErr))
+		err := MaybeInitiateMongoServer(InitiateMongoParams{
+			DialInfo:       &mgo.DialInfo{Addrs: []string{"localhost:1234"}},
+			MemberHostPort: "localhost:1234",
+			RetryStrategy: RetryStrategy{
+				InitialDelay: time.Millisecond,
+				MaxDelay:     time.Millisecond,
+				MaxAttempts:  test.maxAttempts,
+				Deadline:     time.Hour,
+			},
+		})
+		if test.err == "" {
+			c.Check(err, gc.IsNil)
+		} else {
+			c.Check(err, gc.ErrorMatches, test.err)
+		}
+	}
+}
+
+func (s *InitiateSuite) TestMaybeInitiateMongoServerRecoversPanic(c *gc.C) {
+	s.PatchValue(&dialMongo, func(*mgo.DialInfo) (*mgo.Session, error) {
+		panic("kaboom")
+	})
+	err := MaybeInitiateMongoServer(InitiateMongoParams{
+		DialInfo:       &mgo.DialInfo{Addrs: []string{"localhost:1234"}},
+		MemberHostPort: "localhost:1234",
+	})
+	c.Assert(err, gc.ErrorMatches, "panic in MaybeInitiateMongoServer: kaboom")
+	panicErr, ok := err.(*RecoveredPanicError)
+	c.Assert(ok, gc.Equals, true)
+	c.Assert(panicErr.Value, gc.Equals, "kaboom")
+	c.Assert(panicErr.Stack, gc.Not(gc.HasLen), 0)
+}
+
+func (s *InitiateSuite) TestMaybeInitiateMongoServerRejectsX509WithoutTLSConfig(c *gc.C) {
+	err := MaybeInitiateMongoServer(InitiateMongoParams{
+		DialInfo:       &mgo.DialInfo{Addrs: []string{"localhost:1234"}},
+		MemberHostPort: "localhost:1234",
+		X509Subject:    "CN=juju-client",
+	})
+	c.Assert(err, gc.ErrorMatches, "X509Subject requires TLSConfig to be set")
+}
+
+func (s *InitiateSuite) TestMaybeInitiateMongoServerRejectsX509WithUserPassword(c *gc.C) {
+	err := MaybeInitiateMongoServer(InitiateMongoParams{
+		DialInfo:       &mgo.DialInfo{Addrs: []string{"localhost:1234"}},
+		MemberHostPort: "localhost:1234",
+		User:           "admin",
+		Password:       "secret",
+		X509Subject:    "CN=juju-client",
+		TLSConfig:      &tls.Config{},
+	})
+	c.Assert(err, gc.ErrorMatches, "cannot use both X509Subject and username/password authentication")
+}
+
+func (s *InitiateSuite) TestMaybeInitiateMongoServerInstallsTLSDialServer(c *gc.C) {
+	s.PatchValue(&dialMongo, func(info *mgo.DialInfo) (*mgo.Session, error) {
+		c.Check(info.DialServer, gc.NotNil)
+		return &mgo.Session{}, nil
+	})
+	tlsConfig := &tls.Config{InsecureSkipVerify: true}
+	err := MaybeInitiateMongoServer(InitiateMongoParams{
+		DialInfo:       &mgo.DialInfo{Addrs: []string{"localhost:1234"}},
+		MemberHostPort: "localhost:1234",
+		TLSConfig:      tlsConfig,
+	})
+	c.Assert(err, gc.IsNil)
+}
+
+func (s *InitiateSuite) TestInstallTLSDialServerSetsDialServer(c *gc.C) {
+	info := &mgo.DialInfo{Addrs: []string{"localhost:1234"}}
+	c.Assert(info.DialServer, gc.IsNil)
+	installTLSDialServer(info, &tls.Config{})
+	c.Assert(info.DialServer, gc.NotNil)
+}
+
+// selfSignedServerCert generates a throwaway CA and a server certificate
+// signed by it, for driving a real TLS handshake in tests without a
+// fixture checked into the tree.
+func selfSignedServerCert(c *gc.C) (caPEM []byte, serverCert tls.Certificate) {
+	caKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	c.Assert(err, gc.IsNil)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "juju-cloudsigma test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	c.Assert(err, gc.IsNil)
+	caCert, err := x509.ParseCertificate(caDER)
+	c.Assert(err, gc.IsNil)
+
+	serverKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	c.Assert(err, gc.IsNil)
+	serverTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	serverDER, err := x509.CreateCertificate(rand.Reader, serverTemplate, caCert, &serverKey.PublicKey, caKey)
+	c.Assert(err, gc.IsNil)
+
+	serverCert, err = tls.X509KeyPair(
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: serverDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(serverKey)}),
+	)
+	c.Assert(err, gc.IsNil)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}), serverCert
+}
+
+// acceptOnce accepts a single connection on l and echoes whatever it
+// reads back to the client, so dialTLS has something to complete a
+// handshake -- and, if it wants to, a round trip -- against.
+func acceptOnce(l net.Listener) {
+	conn, err := l.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	io.Copy(conn, conn)
+}
+
+func (s *InitiateSuite) TestDialTLSAcceptsCertSignedByTrustedCA(c *gc.C) {
+	caPEM, serverCert := selfSignedServerCert(c)
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{serverCert}})
+	c.Assert(err, gc.IsNil)
+	defer l.Close()
+	go acceptOnce(l)
+
+	pool := x509.NewCertPool()
+	c.Assert(pool.AppendCertsFromPEM(caPEM), gc.Equals, true)
+	conn, err := dialTLS(l.Addr().String(), &tls.Config{RootCAs: pool, ServerName: "localhost"})
+	c.Assert(err, gc.IsNil)
+	conn.Close()
+}
+
+func (s *InitiateSuite) TestDialTLSRejectsCertFromUntrustedCA(c *gc.C) {
+	_, serverCert := selfSignedServerCert(c)
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{serverCert}})
+	c.Assert(err, gc.IsNil)
+	defer l.Close()
+	go acceptOnce(l)
+
+	// An empty pool trusts no CA at all, standing in for a server
+	// presenting the wrong certificate: the handshake must fail rather
+	// than silently succeed (e.g. because of a stray InsecureSkipVerify).
+	_, err = dialTLS(l.Addr().String(), &tls.Config{RootCAs: x509.NewCertPool(), ServerName: "localhost"})
+	c.Assert(err, gc.ErrorMatches, ".*(certificate signed by unknown authority|certificate is not trusted).*")
+}
+
+func (s *InitiateSuite) TestMaybeInitiateMongoServerX509Success(c *gc.C) {
+	s.PatchValue(&dialMongo, func(*mgo.DialInfo) (*mgo.Session, error) {
+		return &mgo.Session{}, nil
+	})
+	var gotSubject string
+	s.PatchValue(&authenticateX509, func(_ *mgo.Session, subject string) error {
+		gotSubject = subject
+		return nil
+	})
+	err := MaybeInitiateMongoServer(InitiateMongoParams{
+		DialInfo:       &mgo.DialInfo{Addrs: []string{"localhost:1234"}},
+		MemberHostPort: "localhost:1234",
+		TLSConfig:      &tls.Config{},
+		X509Subject:    "CN=juju-client",
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(gotSubject, gc.Equals, "CN=juju-client")
+}
+
+func (s *InitiateSuite) TestMaybeInitiateMongoServerX509WrongSubjectRejected(c *gc.C) {
+	s.PatchValue(&dialMongo, func(*mgo.DialInfo) (*mgo.Session, error) {
+		return &mgo.Session{}, nil
+	})
+	s.PatchValue(&authenticateX509, func(*mgo.Session, string) error {
+		return fmt.Errorf("not authorized for authenticate")
+	})
+	err := MaybeInitiateMongoServer(InitiateMongoParams{
+		DialInfo:       &mgo.DialInfo{Addrs: []string{"localhost:1234"}},
+		MemberHostPort: "localhost:1234",
+		TLSConfig:      &tls.Config{},
+		X509Subject:    "CN=wrong-client",
+	})
+	c.Assert(err, gc.ErrorMatches, "cannot authenticate with MONGODB-X509: not authorized for authenticate")
+}
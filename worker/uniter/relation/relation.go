@@ -11,6 +11,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // State describes the state of a relation.
@@ -77,6 +78,11 @@ func (s *State) Validate(hi hook.Info) (err error) {
 // relation. Concurrent modifications to the underlying state directory
 // will have undefined consequences.
 type StateDir struct {
+	// dirPath is the directory under which every relation's StateDir is
+	// persisted; it is kept around so the journal -- which must survive
+	// the deletion of path below -- can be written one level up.
+	dirPath string
+
 	// path identifies the directory holding persistent state.
 	path string
 
@@ -84,6 +90,10 @@ type StateDir struct {
 	// to be synchronized with the true state so long as no concurrent
 	// changes are made to the directory.
 	state State
+
+	// pending holds the hook recorded in the journal when it was found
+	// not to be reflected yet in the unit files read from path.
+	pending *hook.Info
 }
 
 // State returns the current state of the relation.
@@ -91,20 +101,37 @@ func (d *StateDir) State() *State {
 	return d.state.copy()
 }
 
+// PendingHook returns the hook.Info recorded in the journal the last time
+// this StateDir was loaded, if the uniter crashed after the hook was run
+// (or before it started) but before Write persisted the corresponding
+// change. It returns nil if there is nothing to reconcile.
+func (d *StateDir) PendingHook() *hook.Info {
+	return d.pending
+}
+
 // ReadStateDir loads a StateDir from the subdirectory of dirPath named
 // for the supplied RelationId. If the directory does not exist, no error
 // is returned,
 func ReadStateDir(dirPath string, relationId int) (d *StateDir, err error) {
 	d = &StateDir{
+		dirPath,
 		filepath.Join(dirPath, strconv.Itoa(relationId)),
 		State{relationId, map[string]int{}, ""},
+		nil,
 	}
 	defer trivial.ErrorContextf(&err, "cannot load relation state from %q", d.path)
+	dirMissing := false
 	if _, err := os.Stat(d.path); os.IsNotExist(err) {
-		return d, nil
+		dirMissing = true
 	} else if err != nil {
 		return nil, err
 	}
+	if dirMissing {
+		if err := d.reconcileJournal(dirMissing); err != nil {
+			return nil, err
+		}
+		return d, nil
+	}
 	fis, err := ioutil.ReadDir(d.path)
 	if err != nil {
 		return nil, err
@@ -138,6 +165,9 @@ func ReadStateDir(dirPath string, relationId int) (d *StateDir, err error) {
 			d.state.ChangedPending = unitName
 		}
 	}
+	if err := d.reconcileJournal(dirMissing); err != nil {
+		return nil, err
+	}
 	return d, nil
 }
 
@@ -155,28 +185,84 @@ func ReadAllStateDirs(dirPath string) (dirs map[int]*StateDir, err error) {
 		return nil, err
 	}
 	dirs = map[int]*StateDir{}
+	var journalNames []string
 	for _, fi := range fis {
 		// Entries with integer names must be directories containing StateDir
-		// data; all other names will be ignored.
-		relationId, err := strconv.Atoi(fi.Name())
-		if err != nil {
-			// This doesn't look like a relation.
+		// data; ".<id>.journal" entries are handled in a second pass, once
+		// every relation directory has been seen; every other name is
+		// ignored.
+		name := fi.Name()
+		if relationId, err := strconv.Atoi(name); err == nil {
+			dir, err := ReadStateDir(dirPath, relationId)
+			if err != nil {
+				return nil, err
+			}
+			dirs[relationId] = dir
+			continue
+		}
+		if _, ok := journalRelationId(name); ok {
+			journalNames = append(journalNames, name)
+		}
+	}
+	// A ".<id>.journal" left with no matching directory usually means a
+	// relation-broken hook committed (deleting the directory) just before
+	// the journal itself was cleared -- but it can also mean the uniter
+	// crashed before the directory for a brand new relation was ever
+	// created. Load it through the normal reconciliation path rather than
+	// assuming the former, so a still-pending hook is surfaced instead of
+	// being silently discarded along with its journal.
+	for _, name := range journalNames {
+		relationId, _ := journalRelationId(name)
+		if _, found := dirs[relationId]; found {
 			continue
 		}
-		dir, err := ReadStateDir(dirPath, relationId)
+		d, err := ReadStateDir(dirPath, relationId)
 		if err != nil {
 			return nil, err
 		}
-		dirs[relationId] = dir
+		if d.PendingHook() != nil {
+			dirs[relationId] = d
+		}
 	}
 	return dirs, nil
 }
 
+// journalRelationId reports whether name is a ".<id>.journal" file, and
+// if so, the relation id it belongs to.
+func journalRelationId(name string) (int, bool) {
+	if !strings.HasPrefix(name, ".") || !strings.HasSuffix(name, ".journal") {
+		return 0, false
+	}
+	idPart := strings.TrimSuffix(strings.TrimPrefix(name, "."), ".journal")
+	relationId, err := strconv.Atoi(idPart)
+	if err != nil {
+		return 0, false
+	}
+	return relationId, true
+}
+
 // Ensure creates the directory if it does not already exist.
 func (d *StateDir) Ensure() error {
 	return trivial.EnsureDir(d.path)
 }
 
+// PrepareHook writes hi to the relation's journal as its single uncommitted
+// record, replacing any record already there, and must be called before
+// the corresponding hook is run. If the
+// uniter crashes before Write is subsequently called to commit the change,
+// the journal record survives -- even if the hook itself deletes d's
+// directory -- and is surfaced via PendingHook on the next ReadStateDir,
+// so the uniter can decide whether to replay the hook or just roll the
+// on-disk state forward.
+func (d *StateDir) PrepareHook(hi hook.Info) (err error) {
+	defer trivial.ErrorContextf(&err, "failed to prepare %q hook info for %q on state directory", hi.Kind, hi.RemoteUnit)
+	if hi.RelationId != d.state.RelationId {
+		return fmt.Errorf("expected relation %d, got relation %d", d.state.RelationId, hi.RelationId)
+	}
+	rec := journalRecord{hi.Kind, hi.RemoteUnit, hi.ChangeVersion, time.Now().Unix()}
+	return trivial.WriteYaml(d.journalPath(), &rec)
+}
+
 // Write atomically writes to disk the relation state change in hi.
 // It must be called after the respective hook was executed successfully.
 // Write doesn't validate hi but guarantees that successive writes of
@@ -189,7 +275,7 @@ func (d *StateDir) Write(hi hook.Info) (err error) {
 		}
 		// If atomic delete succeeded, update own state.
 		d.state.Members = nil
-		return nil
+		return d.commitJournal()
 	}
 	name := strings.Replace(hi.RemoteUnit, "/", "-", 1)
 	path := filepath.Join(d.path, name)
@@ -199,7 +285,7 @@ func (d *StateDir) Write(hi hook.Info) (err error) {
 		}
 		// If atomic delete succeeded, update own state.
 		delete(d.state.Members, hi.RemoteUnit)
-		return nil
+		return d.commitJournal()
 	}
 	di := diskInfo{&hi.ChangeVersion, hi.Kind == hook.RelationJoined}
 	if err := trivial.WriteYaml(path, &di); err != nil {
@@ -212,11 +298,87 @@ func (d *StateDir) Write(hi hook.Info) (err error) {
 	} else {
 		d.state.ChangedPending = ""
 	}
-	return nil
+	return d.commitJournal()
 }
 
 // diskInfo defines the relation unit data serialization.
 type diskInfo struct {
 	ChangeVersion  *int `yaml:"change-version"`
 	ChangedPending bool `yaml:"changed-pending,omitempty"`
+}
+
+// journalRecord is the on-disk representation of a single in-flight hook.
+// It is written by PrepareHook before the hook runs, and cleared by
+// commitJournal once Write has persisted the corresponding change -- so
+// that any record found on disk at load time must have been left by a
+// hook that ran (or started running) but was never confirmed written.
+type journalRecord struct {
+	Op            hook.Kind `yaml:"op"`
+	RemoteUnit    string    `yaml:"remote-unit"`
+	ChangeVersion int       `yaml:"change-version"`
+	Timestamp     int64     `yaml:"timestamp"`
+}
+
+// journalPath returns the path of the journal file for d. It is kept
+// alongside the sibling relation directories, one level up from d.path,
+// so that a RelationBroken record -- whose hook deletes d.path entirely --
+// is not lost along with the directory it describes.
+func (d *StateDir) journalPath() string {
+	return filepath.Join(d.dirPath, fmt.Sprintf(".%d.journal", d.state.RelationId))
+}
+
+// commitJournal marks the current journal record (if any) as committed,
+// by removing it: Write has just persisted the change it describes, so
+// there is nothing left to reconcile on the next load.
+func (d *StateDir) commitJournal() error {
+	if err := os.Remove(d.journalPath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	d.pending = nil
+	return nil
+}
+
+// reconcileJournal reads the journal for d, if any, and either clears it
+// -- if the state already read from disk shows the recorded hook was
+// successfully written -- or records it as a pending hook to be resolved
+// by the uniter. dirMissing is true when d.path does not exist; it is needed
+// to resolve a recorded RelationBroken hook, whose completion is exactly
+// the deletion of d.path.
+func (d *StateDir) reconcileJournal(dirMissing bool) (err error) {
+	defer trivial.ErrorContextf(&err, "cannot reconcile relation journal in %q", d.dirPath)
+	var rec journalRecord
+	if err := trivial.ReadYaml(d.journalPath(), &rec); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	var committed bool
+	switch rec.Op {
+	case hook.RelationBroken:
+		committed = dirMissing
+	case hook.RelationDeparted:
+		_, stillMember := d.state.Members[rec.RemoteUnit]
+		committed = !stillMember
+	default:
+		// RelationJoined or RelationChanged: committed once the recorded
+		// change version -- and no earlier one -- is on disk for the unit.
+		// A joined unit is written with changed-pending set, so a
+		// RelationChanged record is only committed once that flag is clear.
+		v, ok := d.state.Members[rec.RemoteUnit]
+		committed = ok && v == rec.ChangeVersion
+		if rec.Op == hook.RelationChanged && d.state.ChangedPending == rec.RemoteUnit {
+			committed = false
+		}
+	}
+	if committed {
+		return d.commitJournal()
+	}
+	d.pending = &hook.Info{
+		RelationId:    d.state.RelationId,
+		Kind:          rec.Op,
+		RemoteUnit:    rec.RemoteUnit,
+		ChangeVersion: rec.ChangeVersion,
+	}
+	return nil
 }
\ No newline at end of file
@@ -0,0 +1,111 @@
+package relation
+
+import (
+	"math/rand"
+	"testing"
+
+	. "launchpad.net/gocheck"
+
+	"launchpad.net/juju-core/worker/uniter/hook"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type JournalSuite struct{}
+
+var _ = Suite(&JournalSuite{})
+
+// legalHooks returns the single legal sequence of hook.Infos that a unit
+// joining, changing numChanges times, departing and then (once no members
+// remain) running relation-broken would produce.
+func legalHooks(relationId int, unit string, numChanges int) []hook.Info {
+	hooks := []hook.Info{
+		{RelationId: relationId, Kind: hook.RelationJoined, RemoteUnit: unit, ChangeVersion: 0},
+		{RelationId: relationId, Kind: hook.RelationChanged, RemoteUnit: unit, ChangeVersion: 0},
+	}
+	for v := 1; v <= numChanges; v++ {
+		hooks = append(hooks, hook.Info{
+			RelationId: relationId, Kind: hook.RelationChanged, RemoteUnit: unit, ChangeVersion: v,
+		})
+	}
+	hooks = append(hooks,
+		hook.Info{RelationId: relationId, Kind: hook.RelationDeparted, RemoteUnit: unit, ChangeVersion: numChanges},
+		hook.Info{RelationId: relationId, Kind: hook.RelationBroken},
+	)
+	return hooks
+}
+
+// TestJournalSurvivesCrash runs many random hook sequences through a
+// StateDir, injecting a simulated crash -- PrepareHook without a following
+// Write -- at random points, and checks that every reload sees either the
+// hook fully committed or still pending, but never anything in between.
+func (s *JournalSuite) TestJournalSurvivesCrash(c *C) {
+	const relationId = 0
+	const trials = 50
+	rng := rand.New(rand.NewSource(1))
+
+	for trial := 0; trial < trials; trial++ {
+		dirPath := c.MkDir()
+		hooks := legalHooks(relationId, "mysql/0", rng.Intn(5))
+		crash := rng.Intn(len(hooks))
+
+		d, err := ReadStateDir(dirPath, relationId)
+		c.Assert(err, IsNil)
+		c.Assert(d.PendingHook(), IsNil)
+
+		for i, hi := range hooks {
+			if hi.Kind != hook.RelationBroken {
+				c.Assert(d.Ensure(), IsNil)
+			}
+			c.Assert(d.PrepareHook(hi), IsNil)
+
+			if i == crash {
+				// Simulate a crash between PrepareHook and Write: the
+				// journal record is on disk but the corresponding change
+				// was never persisted. Reloading must recover exactly
+				// the hook that was in flight, so the uniter can decide
+				// whether to resume or replay it.
+				reloaded, err := ReadStateDir(dirPath, relationId)
+				c.Assert(err, IsNil)
+				c.Assert(reloaded.PendingHook(), DeepEquals, &hi)
+				c.Assert(reloaded.State(), DeepEquals, d.State())
+
+				c.Assert(reloaded.Write(hi), IsNil)
+				c.Assert(reloaded.PendingHook(), IsNil)
+				d = reloaded
+				continue
+			}
+
+			c.Assert(d.Write(hi), IsNil)
+
+			reloaded, err := ReadStateDir(dirPath, relationId)
+			c.Assert(err, IsNil)
+			c.Assert(reloaded.PendingHook(), IsNil)
+			c.Assert(reloaded.State(), DeepEquals, d.State())
+			d = reloaded
+		}
+	}
+}
+
+// TestJournalSurvivesCrashBeforeDirectoryExists checks the case where the
+// uniter crashes after preparing the very first hook for a brand new
+// relation, before the relation's own subdirectory is ever created -- the
+// journal then has no sibling directory at all.
+func (s *JournalSuite) TestJournalSurvivesCrashBeforeDirectoryExists(c *C) {
+	dirPath := c.MkDir()
+	hi := hook.Info{RelationId: 0, Kind: hook.RelationJoined, RemoteUnit: "mysql/0", ChangeVersion: 0}
+
+	d, err := ReadStateDir(dirPath, 0)
+	c.Assert(err, IsNil)
+	c.Assert(d.PrepareHook(hi), IsNil)
+
+	dirs, err := ReadAllStateDirs(dirPath)
+	c.Assert(err, IsNil)
+	reloaded, ok := dirs[0]
+	c.Assert(ok, Equals, true)
+	c.Assert(reloaded.PendingHook(), DeepEquals, &hi)
+
+	c.Assert(reloaded.Ensure(), IsNil)
+	c.Assert(reloaded.Write(hi), IsNil)
+	c.Assert(reloaded.PendingHook(), IsNil)
+}